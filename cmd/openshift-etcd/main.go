@@ -0,0 +1,17 @@
+// Command openshift-etcd manages the membership of a running embedded etcd
+// cluster, so a cluster-admin can add, remove, or list members without
+// installing a separate etcdctl binary.
+package main
+
+import (
+	"os"
+
+	"github.com/openshift/origin/pkg/cmd/server/etcd"
+)
+
+func main() {
+	cmd := etcd.NewCommandAdmin("openshift-etcd")
+	if err := cmd.Execute(); err != nil {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,40 @@
+package dockerregistry
+
+import (
+	"fmt"
+
+	"github.com/docker/distribution/configuration"
+	"gopkg.in/yaml.v2"
+)
+
+// ProxyConfig describes a remote registry this instance should pull through
+// and cache for repositories it does not already host locally.
+type ProxyConfig struct {
+	// RemoteURL is the base URL of the upstream registry, e.g.
+	// "https://registry-1.docker.io" or "https://quay.io".
+	RemoteURL string `yaml:"remoteurl"`
+	// Username and Password authenticate against RemoteURL, if required.
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+}
+
+// Configuration extends the upstream docker/distribution configuration with
+// the OpenShift-specific sections dockerregistry.Execute understands.
+type Configuration struct {
+	configuration.Configuration `yaml:",inline"`
+
+	// Proxy, when present, turns this registry into a caching pull-through
+	// for RemoteURL. Manifests and blobs found in local storage are always
+	// served from there; only ones missing locally fall through, including
+	// missing tags of a repository that otherwise exists locally.
+	Proxy *ProxyConfig `yaml:"proxy"`
+}
+
+// ParseConfiguration decodes raw registry configuration YAML.
+func ParseConfiguration(in []byte) (*Configuration, error) {
+	config := &Configuration{}
+	if err := yaml.Unmarshal(in, config); err != nil {
+		return nil, fmt.Errorf("error parsing registry configuration: %v", err)
+	}
+	return config, nil
+}
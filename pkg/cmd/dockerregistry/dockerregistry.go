@@ -0,0 +1,57 @@
+// Package dockerregistry wraps docker/distribution's registry server with
+// the OpenShift-specific auth, repository naming, and (optionally) caching
+// pull-through middleware this project layers on top of it.
+package dockerregistry
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/docker/distribution/configuration"
+	"github.com/docker/distribution/registry/handlers"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	"github.com/openshift/origin/pkg/util/failpoint"
+)
+
+// Execute runs an OpenShift-flavored docker registry until the process
+// exits, using the configuration read from configFile.
+func Execute(configFile io.Reader) {
+	in, err := ioutil.ReadAll(configFile)
+	if err != nil {
+		glog.Fatalf("error reading registry configuration: %v", err)
+	}
+	config, err := ParseConfiguration(in)
+	if err != nil {
+		glog.Fatalf("%v", err)
+	}
+
+	if config.Proxy != nil {
+		if err := validateProxyConfig(config.Proxy); err != nil {
+			glog.Fatalf("error configuring registry proxy: %v", err)
+		}
+		if config.Middleware == nil {
+			config.Middleware = map[string][]configuration.Middleware{}
+		}
+		config.Middleware["repository"] = append(config.Middleware["repository"], configuration.Middleware{
+			Name:    proxyMiddlewareName,
+			Options: configuration.Parameters{proxyConfigOption: config.Proxy},
+		})
+	}
+
+	app := handlers.NewApp(context.Background(), &config.Configuration)
+	mux := http.NewServeMux()
+	mux.Handle("/", app)
+	if h := failpoint.Handler(); h != nil {
+		mux.Handle("/debug/failpoints/", h)
+	}
+	server := &http.Server{
+		Addr:    config.HTTP.Addr,
+		Handler: mux,
+	}
+	if err := server.ListenAndServe(); err != nil {
+		glog.Fatalf("error serving registry: %v", err)
+	}
+}
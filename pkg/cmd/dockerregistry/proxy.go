@@ -0,0 +1,349 @@
+package dockerregistry
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/digest"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/registry/client"
+	"github.com/docker/distribution/registry/storage"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	kapi "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	kclient "github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+
+	osclient "github.com/openshift/origin/pkg/client"
+	imageapi "github.com/openshift/origin/pkg/image/api"
+	"github.com/openshift/origin/pkg/util/failpoint"
+)
+
+// proxyMiddlewareName is the repository middleware name pull-through mode
+// registers itself under. It is added to the "middleware.repository" list
+// in the registry configuration automatically when a "proxy:" block is
+// present, so operators never need to name it directly.
+const proxyMiddlewareName = "openshift-proxy"
+
+func init() {
+	storage.RegisterRepositoryMiddleware(proxyMiddlewareName, storage.InitFunc(newProxiedRepository))
+}
+
+// proxyConfigOption is the key newProxiedRepository's options map carries its
+// *ProxyConfig under. docker/distribution constructs repository middleware
+// through a package-level registry keyed only by name, with per-instance
+// configuration passed through the options it was registered with, so this
+// (rather than a package-level variable) is how each repository gets the
+// ProxyConfig Execute read off of it, keeping it safe for a single process
+// to run more than one registry, each with its own proxy config.
+const proxyConfigOption = "proxyConfig"
+
+// validateProxyConfig checks that cfg has everything newProxiedRepository
+// needs before Execute registers it.
+func validateProxyConfig(cfg *ProxyConfig) error {
+	if len(cfg.RemoteURL) == 0 {
+		return fmt.Errorf("proxy.remoteurl is required")
+	}
+	return nil
+}
+
+// newProxiedRepository wraps repo so that manifest and blob reads which miss
+// locally fall through to the upstream named by the *ProxyConfig passed in
+// options under proxyConfigOption.
+func newProxiedRepository(ctx context.Context, repo distribution.Repository, options map[string]interface{}) (distribution.Repository, error) {
+	cfg, ok := options[proxyConfigOption].(*ProxyConfig)
+	if !ok || cfg == nil {
+		return repo, nil
+	}
+	remote, err := client.NewRepository(ctx, repo.Name(), cfg.RemoteURL, proxyTransport(cfg))
+	if err != nil {
+		return nil, fmt.Errorf("error creating upstream client for %s: %v", repo.Name(), err)
+	}
+	return &proxiedRepository{Repository: repo, remote: remote, remoteURL: cfg.RemoteURL}, nil
+}
+
+// proxyTransport returns the http.RoundTripper newProxiedRepository's client
+// should make upstream requests with: plain http.DefaultTransport, or, when
+// cfg carries credentials, one that attaches them as HTTP basic auth so a
+// credentialed upstream like a private Docker Hub or Quay repository can be
+// pulled through too.
+func proxyTransport(cfg *ProxyConfig) http.RoundTripper {
+	if len(cfg.Username) == 0 && len(cfg.Password) == 0 {
+		return http.DefaultTransport
+	}
+	return &basicAuthTransport{username: cfg.Username, password: cfg.Password, base: http.DefaultTransport}
+}
+
+// basicAuthTransport attaches a fixed username/password to every request as
+// HTTP basic auth before delegating to base.
+type basicAuthTransport struct {
+	username, password string
+	base               http.RoundTripper
+}
+
+func (t *basicAuthTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = cloneRequest(req)
+	req.SetBasicAuth(t.username, t.password)
+	return t.base.RoundTrip(req)
+}
+
+// cloneRequest returns a shallow copy of req with its own Header map, so
+// setting a header on the clone never mutates the original request a caller
+// may still hold a reference to.
+func cloneRequest(req *http.Request) *http.Request {
+	clone := *req
+	clone.Header = make(http.Header, len(req.Header))
+	for k, v := range req.Header {
+		clone.Header[k] = v
+	}
+	return &clone
+}
+
+// proxiedRepository is a distribution.Repository that serves from local
+// storage when it can and otherwise pulls through from an upstream registry,
+// caching what it fetches and mirroring it into the matching ImageStream so
+// it shows up the same way a directly pushed image would.
+type proxiedRepository struct {
+	distribution.Repository
+	remote    distribution.Repository
+	remoteURL string
+}
+
+// Blobs returns a BlobStore that serves layers from local storage when it
+// can and otherwise pulls them through from upstream, caching each one
+// locally the first time it is fetched. Without this override, a manifest
+// pulled through by Manifests.Get would cache fine but every one of its
+// layers would 404 forever, since they were never fetched from upstream.
+func (p *proxiedRepository) Blobs(ctx context.Context) distribution.BlobStore {
+	return &proxiedBlobStore{repo: p.Repository.Name(), local: p.Repository.Blobs(ctx), remote: p.remote.Blobs(ctx)}
+}
+
+func (p *proxiedRepository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	local, err := p.Repository.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := p.remote.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &proxiedManifestService{repo: p.Repository.Name(), remoteURL: p.remoteURL, local: local, remote: remote}, nil
+}
+
+type proxiedManifestService struct {
+	repo      string
+	remoteURL string
+	local     distribution.ManifestService
+	remote    distribution.ManifestService
+}
+
+// Get returns the manifest identified by tagOrDigest, pulling it through
+// from upstream, caching it locally, and mirroring it into the matching
+// ImageStream the first time it is requested.
+func (p *proxiedManifestService) Get(tagOrDigest string) (*manifest.SignedManifest, error) {
+	if local, err := p.local.Get(tagOrDigest); err == nil {
+		return local, nil
+	}
+
+	remote, err := p.remote.Get(tagOrDigest)
+	if err != nil {
+		return nil, fmt.Errorf("error pulling %s:%s through from upstream: %v", p.repo, tagOrDigest, err)
+	}
+
+	// gofail: var ManifestPutPartial string
+	if _, ok := failpoint.Eval("registry/ManifestPutPartial"); ok {
+		glog.Errorf("error caching pulled-through manifest %s:%s locally: injected partial write", p.repo, tagOrDigest)
+	} else if err := p.local.Put(remote); err != nil {
+		glog.Errorf("error caching pulled-through manifest %s:%s locally: %v", p.repo, tagOrDigest, err)
+	}
+	if err := mirrorToImageStream(p.repo, p.remoteURL, tagOrDigest, remote); err != nil {
+		glog.Errorf("error mirroring pulled-through manifest %s:%s into ImageStream: %v", p.repo, tagOrDigest, err)
+	}
+	return remote, nil
+}
+
+func (p *proxiedManifestService) Exists(tagOrDigest string) (bool, error) {
+	if ok, err := p.local.Exists(tagOrDigest); err == nil && ok {
+		return true, nil
+	}
+	return p.remote.Exists(tagOrDigest)
+}
+
+func (p *proxiedManifestService) Put(signed *manifest.SignedManifest) error {
+	return p.local.Put(signed)
+}
+
+func (p *proxiedManifestService) Delete(tagOrDigest string) error {
+	return p.local.Delete(tagOrDigest)
+}
+
+func (p *proxiedManifestService) Tags() ([]string, error) {
+	return p.local.Tags()
+}
+
+func (p *proxiedManifestService) ExistsByTag(tag string) (bool, error) {
+	return p.Exists(tag)
+}
+
+// proxiedBlobStore is a distribution.BlobStore that serves from local
+// storage when it can and otherwise pulls a layer through from upstream,
+// caching it locally, mirroring the read-through/cache pattern
+// proxiedManifestService.Get already uses for manifests. Writes always go
+// straight to local storage; this registry is never the write target for a
+// proxied repository.
+type proxiedBlobStore struct {
+	repo   string
+	local  distribution.BlobStore
+	remote distribution.BlobStore
+}
+
+func (p *proxiedBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	if desc, err := p.local.Stat(ctx, dgst); err == nil {
+		return desc, nil
+	}
+	return p.remote.Stat(ctx, dgst)
+}
+
+func (p *proxiedBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if content, err := p.local.Get(ctx, dgst); err == nil {
+		return content, nil
+	}
+
+	content, err := p.remote.Get(ctx, dgst)
+	if err != nil {
+		return nil, fmt.Errorf("error pulling blob %s@%s through from upstream: %v", p.repo, dgst, err)
+	}
+	if _, err := p.local.Put(ctx, "", content); err != nil {
+		glog.Errorf("error caching pulled-through blob %s@%s locally: %v", p.repo, dgst, err)
+	}
+	return content, nil
+}
+
+func (p *proxiedBlobStore) Open(ctx context.Context, dgst digest.Digest) (distribution.ReadSeekCloser, error) {
+	if reader, err := p.local.Open(ctx, dgst); err == nil {
+		return reader, nil
+	}
+	content, err := p.Get(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	return nopCloserReadSeeker{bytes.NewReader(content)}, nil
+}
+
+func (p *proxiedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	if err := p.local.ServeBlob(ctx, w, r, dgst); err == nil {
+		return nil
+	}
+	if _, err := p.Get(ctx, dgst); err != nil {
+		return err
+	}
+	return p.local.ServeBlob(ctx, w, r, dgst)
+}
+
+func (p *proxiedBlobStore) Put(ctx context.Context, mediaType string, content []byte) (distribution.Descriptor, error) {
+	return p.local.Put(ctx, mediaType, content)
+}
+
+func (p *proxiedBlobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	return p.local.Create(ctx, options...)
+}
+
+func (p *proxiedBlobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
+	return p.local.Resume(ctx, id)
+}
+
+func (p *proxiedBlobStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	return p.local.Delete(ctx, dgst)
+}
+
+// nopCloserReadSeeker adapts a *bytes.Reader, which already satisfies
+// io.Reader and io.Seeker, to distribution.ReadSeekCloser for blob content
+// pulled through from upstream and held only in memory.
+type nopCloserReadSeeker struct {
+	*bytes.Reader
+}
+
+func (nopCloserReadSeeker) Close() error { return nil }
+
+// mirrorToImageStream records tag as pointing at signed's digest on the
+// ImageStream matching repo, creating the ImageStream if this is the first
+// time anything has been pulled through for it. remoteURL is recorded on the
+// tag event as the upstream the image was pulled through from.
+func mirrorToImageStream(repo, remoteURL, tag string, signed *manifest.SignedManifest) error {
+	namespace, name, err := splitRepositoryName(repo)
+	if err != nil {
+		return err
+	}
+
+	oc, err := adminOpenShiftClient()
+	if err != nil {
+		return fmt.Errorf("error building OpenShift client: %v", err)
+	}
+
+	payload, err := signed.Payload()
+	if err != nil {
+		return fmt.Errorf("error reading manifest payload: %v", err)
+	}
+	dgst, err := digest.FromBytes(payload)
+	if err != nil {
+		return fmt.Errorf("error computing manifest digest: %v", err)
+	}
+
+	stream, err := oc.ImageStreams(namespace).Get(name)
+	if err != nil {
+		stream = &imageapi.ImageStream{
+			ObjectMeta: kapi.ObjectMeta{Namespace: namespace, Name: name},
+			Status:     imageapi.ImageStreamStatus{Tags: map[string]imageapi.TagEventList{}},
+		}
+		if stream, err = oc.ImageStreams(namespace).Create(stream); err != nil {
+			return fmt.Errorf("error creating mirrored ImageStream %s/%s: %v", namespace, name, err)
+		}
+	}
+	if stream.Status.Tags == nil {
+		stream.Status.Tags = map[string]imageapi.TagEventList{}
+	}
+
+	events := stream.Status.Tags[tag]
+	events.Items = append([]imageapi.TagEvent{{
+		DockerImageReference: fmt.Sprintf("%s/%s/%s@%s", remoteURL, namespace, name, dgst.String()),
+		Image:                dgst.String(),
+	}}, events.Items...)
+	stream.Status.Tags[tag] = events
+
+	// gofail: var UpdateConflict string
+	if term, ok := failpoint.Eval("imagestream/UpdateConflict"); ok {
+		return fmt.Errorf("injected ImageStream update conflict for %s/%s: %s", namespace, name, term)
+	}
+
+	_, err = oc.ImageStreams(namespace).UpdateStatus(stream)
+	return err
+}
+
+// splitRepositoryName splits a "namespace/name" repository into its two
+// components, the same two-segment form OpenShift repository names always
+// take.
+func splitRepositoryName(repo string) (namespace, name string, err error) {
+	parts := strings.SplitN(repo, "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return "", "", fmt.Errorf("invalid repository name %q, expected namespace/name", repo)
+	}
+	return parts[0], parts[1], nil
+}
+
+// adminOpenShiftClient builds an OpenShift REST client from the same
+// OPENSHIFT_MASTER/OPENSHIFT_CA_DATA/OPENSHIFT_CERT_DATA/OPENSHIFT_KEY_DATA
+// environment variables the "openshift" auth middleware already uses to
+// reach the master.
+func adminOpenShiftClient() (*osclient.Client, error) {
+	config := &kclient.Config{
+		Host:     os.Getenv("OPENSHIFT_MASTER"),
+		CAData:   []byte(os.Getenv("OPENSHIFT_CA_DATA")),
+		CertData: []byte(os.Getenv("OPENSHIFT_CERT_DATA")),
+		KeyData:  []byte(os.Getenv("OPENSHIFT_KEY_DATA")),
+	}
+	return osclient.New(config)
+}
@@ -0,0 +1,68 @@
+// Package api holds the unversioned configuration types read from the
+// master and node config files, and the embedded etcd server config nested
+// under them.
+package api
+
+// CertInfo points at an on-disk certificate/key pair.
+type CertInfo struct {
+	CertFile string
+	KeyFile  string
+}
+
+// ServingInfo describes how a server listens: the address it binds, the
+// certificate it serves (if any), and the CA it trusts for client certs.
+type ServingInfo struct {
+	BindAddress string
+	ServerCert  CertInfo
+	ClientCA    string
+}
+
+// UseTLS reports whether servingInfo is configured to serve over TLS.
+func UseTLS(servingInfo ServingInfo) bool {
+	return len(servingInfo.ServerCert.CertFile) > 0
+}
+
+// EtcdConnectionInfo is what a client needs to reach an etcd cluster: one or
+// more member client URLs, and, optionally, the TLS trust material to do so
+// over HTTPS.
+type EtcdConnectionInfo struct {
+	URLs       []string
+	CA         string
+	ClientCert CertInfo
+}
+
+// EtcdConfig configures the etcd server a master embeds and runs in-process.
+type EtcdConfig struct {
+	ServingInfo     ServingInfo
+	PeerServingInfo ServingInfo
+
+	Address     string
+	PeerAddress string
+	StorageDir  string
+
+	// Peers statically lists this member's initial cluster, in etcd's own
+	// "name=peerURL,..." form, e.g. "member1=https://10.0.0.1:7001". Set
+	// when cluster membership is known up front. Mutually exclusive with
+	// DiscoverySRV and DiscoveryURL.
+	Peers []string
+
+	// DiscoverySRV is a DNS domain to resolve "_etcd-server._tcp.<domain>"
+	// and "_etcd-server-ssl._tcp.<domain>" SRV records under to find peers,
+	// the same convention etcd's own --discovery-srv flag uses. Mutually
+	// exclusive with Peers and DiscoveryURL.
+	DiscoverySRV string
+
+	// DiscoveryURL is an etcd discovery service URL new members register
+	// with to learn the rest of the cluster. Mutually exclusive with Peers
+	// and DiscoverySRV.
+	DiscoveryURL string
+
+	// InitialClusterState is "new" or "existing", mirroring etcd's own
+	// --initial-cluster-state flag. Defaults to "new" when empty.
+	InitialClusterState string
+}
+
+// MasterConfig configures an OpenShift master process.
+type MasterConfig struct {
+	EtcdClientInfo EtcdConnectionInfo
+}
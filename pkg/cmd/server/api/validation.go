@@ -0,0 +1,30 @@
+package api
+
+import "fmt"
+
+// ValidateEtcdConfig checks that config's peer-discovery and
+// initial-cluster-state fields are individually well-formed and not set in
+// combinations buildInitialCluster can't sensibly resolve.
+func ValidateEtcdConfig(config *EtcdConfig) error {
+	set := 0
+	if len(config.Peers) > 0 {
+		set++
+	}
+	if len(config.DiscoverySRV) > 0 {
+		set++
+	}
+	if len(config.DiscoveryURL) > 0 {
+		set++
+	}
+	if set > 1 {
+		return fmt.Errorf("peers, discoverySRV, and discoveryURL are mutually exclusive, but more than one was set")
+	}
+
+	switch config.InitialClusterState {
+	case "", "new", "existing":
+	default:
+		return fmt.Errorf("initialClusterState must be \"new\" or \"existing\", got %q", config.InitialClusterState)
+	}
+
+	return nil
+}
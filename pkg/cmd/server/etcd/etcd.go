@@ -2,6 +2,8 @@ package etcd
 
 import (
 	"fmt"
+	"net"
+	"strings"
 	"time"
 
 	etcdclient "github.com/coreos/go-etcd/etcd"
@@ -11,10 +13,54 @@ import (
 
 	"github.com/openshift/origin/pkg/api/latest"
 	configapi "github.com/openshift/origin/pkg/cmd/server/api"
+	"github.com/openshift/origin/pkg/util/failpoint"
 )
 
-// RunEtcd starts an etcd server and runs it forever
-func RunEtcd(etcdServerConfig *configapi.EtcdConfig) {
+// Initial cluster states recognized by the embedded etcd server. They mirror
+// etcd's own --initial-cluster-state flag: "new" bootstraps a fresh cluster,
+// "existing" joins a cluster that has already been bootstrapped by another
+// member.
+const (
+	ClusterStateNew      = "new"
+	ClusterStateExisting = "existing"
+)
+
+// EmbeddedEtcd is a handle to an etcd server started in-process by RunEtcd.
+// It mirrors the shape of coreos/etcd's own embed.Etcd handle so callers -
+// notably tests that need a deterministic point at which the server has
+// finished joining its cluster - don't have to poll for readiness.
+type EmbeddedEtcd struct {
+	ready   <-chan struct{}
+	stopped <-chan struct{}
+	errc    chan error
+	stop    func()
+}
+
+// ReadyNotify returns a channel that is closed once the embedded server has
+// joined its cluster and is ready to serve client requests.
+func (e *EmbeddedEtcd) ReadyNotify() <-chan struct{} {
+	return e.ready
+}
+
+// Err returns a channel that is closed once the server stops running. No
+// error value is ever sent on it, whatever the reason the server stopped.
+func (e *EmbeddedEtcd) Err() <-chan error {
+	return e.errc
+}
+
+// Close stops the embedded etcd server and blocks until it has shut down.
+func (e *EmbeddedEtcd) Close() {
+	e.stop()
+	<-e.stopped
+}
+
+// RunEtcd starts an etcd server in-process and returns a handle to it. The
+// server keeps running until the returned handle's Close method is called.
+func RunEtcd(etcdServerConfig *configapi.EtcdConfig) (*EmbeddedEtcd, error) {
+	if err := configapi.ValidateEtcdConfig(etcdServerConfig); err != nil {
+		return nil, fmt.Errorf("invalid etcd config: %v", err)
+	}
+
 	cfg := &config{
 		name: defaultName,
 		dir:  etcdServerConfig.StorageDir,
@@ -33,7 +79,7 @@ func RunEtcd(etcdServerConfig *configapi.EtcdConfig) {
 		cfg.clientTLSInfo.KeyFile = etcdServerConfig.ServingInfo.ServerCert.KeyFile
 	}
 	if cfg.lcurls, err = urlsFromStrings(etcdServerConfig.ServingInfo.BindAddress, cfg.clientTLSInfo); err != nil {
-		glog.Fatalf("Unable to build etcd client URLs: %v", err)
+		return nil, fmt.Errorf("unable to build etcd client URLs: %v", err)
 	}
 
 	if configapi.UseTLS(etcdServerConfig.PeerServingInfo) {
@@ -42,41 +88,126 @@ func RunEtcd(etcdServerConfig *configapi.EtcdConfig) {
 		cfg.peerTLSInfo.KeyFile = etcdServerConfig.PeerServingInfo.ServerCert.KeyFile
 	}
 	if cfg.lpurls, err = urlsFromStrings(etcdServerConfig.PeerServingInfo.BindAddress, cfg.peerTLSInfo); err != nil {
-		glog.Fatalf("Unable to build etcd peer URLs: %v", err)
+		return nil, fmt.Errorf("unable to build etcd peer URLs: %v", err)
 	}
 
 	if cfg.acurls, err = urlsFromStrings(etcdServerConfig.Address, cfg.clientTLSInfo); err != nil {
-		glog.Fatalf("Unable to build etcd announce client URLs: %v", err)
+		return nil, fmt.Errorf("unable to build etcd announce client URLs: %v", err)
 	}
 	if cfg.apurls, err = urlsFromStrings(etcdServerConfig.PeerAddress, cfg.peerTLSInfo); err != nil {
-		glog.Fatalf("Unable to build etcd announce peer URLs: %v", err)
+		return nil, fmt.Errorf("unable to build etcd announce peer URLs: %v", err)
 	}
 
 	if err := cfg.resolveUrls(); err != nil {
-		glog.Fatalf("Unable to resolve etcd URLs: %v", err)
+		return nil, fmt.Errorf("unable to resolve etcd URLs: %v", err)
 	}
 
-	cfg.initialCluster = fmt.Sprintf("%s=%s", cfg.name, cfg.apurls[0].String())
+	if err := buildInitialCluster(cfg, etcdServerConfig); err != nil {
+		return nil, fmt.Errorf("unable to determine etcd initial cluster membership: %v", err)
+	}
 
-	stopped, err := startEtcd(cfg)
+	ready, stopped, stop, err := startEtcd(cfg)
 	if err != nil {
-		glog.Fatalf("Unable to start etcd: %v", err)
+		return nil, fmt.Errorf("unable to start etcd: %v", err)
+	}
+
+	embedded := &EmbeddedEtcd{
+		ready:   ready,
+		stopped: stopped,
+		stop:    stop,
+		errc:    make(chan error, 1),
 	}
 	go func() {
-		glog.Infof("Started etcd at %s", etcdServerConfig.Address)
 		<-stopped
+		glog.Infof("etcd at %s stopped", etcdServerConfig.Address)
+		close(embedded.errc)
 	}()
+	glog.Infof("Started etcd at %s", etcdServerConfig.Address)
+	return embedded, nil
 }
 
-// GetAndTestEtcdClient creates an etcd client based on the provided config and waits
-// until etcd server is reachable. It errors out and exits if the server cannot
-// be reached for a certain amount of time.
-func GetAndTestEtcdClient(etcdClientInfo configapi.EtcdConnectionInfo) (*etcdclient.Client, error) {
-	var etcdClient *etcdclient.Client
+// buildInitialCluster determines how this member should join (or found) a
+// cluster. It is resolved in priority order: an explicit static peer list, a
+// DNS SRV-based discovery domain, the etcd discovery service, and finally (the
+// common single-member case) this member standing up the cluster by itself.
+func buildInitialCluster(cfg *config, etcdServerConfig *configapi.EtcdConfig) error {
+	self := fmt.Sprintf("%s=%s", cfg.name, cfg.apurls[0].String())
 
+	switch {
+	case len(etcdServerConfig.Peers) > 0:
+		cfg.initialCluster = strings.Join(etcdServerConfig.Peers, ",")
+
+	case len(etcdServerConfig.DiscoverySRV) > 0:
+		members, err := discoverPeersSRV(etcdServerConfig.DiscoverySRV, self)
+		if err != nil {
+			return fmt.Errorf("unable to discover etcd peers via DNS SRV records under %q: %v", etcdServerConfig.DiscoverySRV, err)
+		}
+		cfg.dnsCluster = etcdServerConfig.DiscoverySRV
+		cfg.initialCluster = members
+
+	case len(etcdServerConfig.DiscoveryURL) > 0:
+		cfg.durl = etcdServerConfig.DiscoveryURL
+		cfg.initialCluster = self
+
+	default:
+		cfg.initialCluster = self
+	}
+
+	cfg.initialClusterState = etcdServerConfig.InitialClusterState
+	if len(cfg.initialClusterState) == 0 {
+		cfg.initialClusterState = ClusterStateNew
+	}
+	return nil
+}
+
+// discoverPeersSRV resolves the "_etcd-server-ssl._tcp.<domain>" and
+// "_etcd-server._tcp.<domain>" SRV records into an etcd initial-cluster
+// string, the same convention etcd itself uses for the --discovery-srv flag.
+// self is added to the discovered set if it isn't already present, so a
+// member can list itself before it is known to DNS.
+func discoverPeersSRV(domain, self string) (string, error) {
+	members := []string{}
+	for _, proto := range []string{"etcd-server-ssl", "etcd-server"} {
+		scheme := "http"
+		if proto == "etcd-server-ssl" {
+			scheme = "https"
+		}
+		_, srvs, err := net.LookupSRV(proto, "tcp", domain)
+		if err != nil {
+			continue
+		}
+		for _, srv := range srvs {
+			host := strings.TrimSuffix(srv.Target, ".")
+			members = append(members, fmt.Sprintf("%s=%s://%s:%d", host, scheme, host, srv.Port))
+		}
+	}
+	if len(members) == 0 {
+		return "", fmt.Errorf("no SRV records found for domain %q", domain)
+	}
+	found := false
+	for _, member := range members {
+		if member == self {
+			found = true
+			break
+		}
+	}
+	if !found {
+		members = append(members, self)
+	}
+	return strings.Join(members, ","), nil
+}
+
+// newEtcdClient builds an etcd client for the given connection info, using
+// TLS or a custom root CA when configured, without attempting to contact the
+// server. Endpoints are reordered so unreachable ones are skipped on the
+// client's next request rather than retried first.
+func newEtcdClient(etcdClientInfo configapi.EtcdConnectionInfo) (*etcdclient.Client, error) {
+	urls := healthyEtcdEndpointsFirst(etcdClientInfo.URLs)
+
+	var etcdClient *etcdclient.Client
 	if len(etcdClientInfo.ClientCert.CertFile) > 0 {
 		tlsClient, err := etcdclient.NewTLSClient(
-			etcdClientInfo.URLs,
+			urls,
 			etcdClientInfo.ClientCert.CertFile,
 			etcdClientInfo.ClientCert.KeyFile,
 			etcdClientInfo.CA,
@@ -86,16 +217,39 @@ func GetAndTestEtcdClient(etcdClientInfo configapi.EtcdConnectionInfo) (*etcdcli
 		}
 		etcdClient = tlsClient
 	} else if len(etcdClientInfo.CA) > 0 {
-		etcdClient = etcdclient.NewClient(etcdClientInfo.URLs)
-		err := etcdClient.AddRootCA(etcdClientInfo.CA)
-		if err != nil {
+		etcdClient = etcdclient.NewClient(urls)
+		if err := etcdClient.AddRootCA(etcdClientInfo.CA); err != nil {
 			return nil, err
 		}
 	} else {
-		etcdClient = etcdclient.NewClient(etcdClientInfo.URLs)
+		etcdClient = etcdclient.NewClient(urls)
+	}
+	etcdClient.SetConsistency(etcdclient.WEAK_CONSISTENCY)
+	return etcdClient, nil
+}
+
+// GetAndTestEtcdClient creates an etcd client based on the provided config and waits
+// until etcd server is reachable. It errors out and exits if the server cannot
+// be reached for a certain amount of time. When more than one endpoint URL is
+// configured, unreachable endpoints are dropped to the back of the list so a
+// downed member is skipped on the client's next request.
+//
+// Callers that already hold an EmbeddedEtcd handle for the server they are
+// connecting to should prefer GetAndTestEtcdClientReady, which blocks on its
+// ReadyNotify channel instead of polling on a fixed interval.
+func GetAndTestEtcdClient(etcdClientInfo configapi.EtcdConnectionInfo) (*etcdclient.Client, error) {
+	etcdClient, err := newEtcdClient(etcdClientInfo)
+	if err != nil {
+		return nil, err
 	}
 
 	for i := 0; ; i++ {
+		// gofail: var GetSlow string
+		if term, ok := failpoint.Eval("etcd/GetSlow"); ok {
+			if delay, err := time.ParseDuration(term); err == nil {
+				time.Sleep(delay)
+			}
+		}
 		// TODO: make sure this works with etcd2 (root key may not exist)
 		_, err := etcdClient.Get("/", false, false)
 		if err == nil || tools.IsEtcdNotFound(err) {
@@ -110,6 +264,74 @@ func GetAndTestEtcdClient(etcdClientInfo configapi.EtcdConnectionInfo) (*etcdcli
 	return etcdClient, nil
 }
 
+// GetAndTestEtcdClientReady creates an etcd client for a server started
+// in-process, blocking on ready instead of polling: once the embedded server
+// reports itself ready, a single connectivity check is enough, making
+// callers - notably integration tests run with `-count=N` - deterministic
+// rather than racing a fixed 50ms retry interval.
+func GetAndTestEtcdClientReady(etcdClientInfo configapi.EtcdConnectionInfo, ready <-chan struct{}) (*etcdclient.Client, error) {
+	select {
+	case <-ready:
+	case <-time.After(30 * time.Second):
+		return nil, fmt.Errorf("etcd did not become ready within 30s")
+	}
+
+	etcdClient, err := newEtcdClient(etcdClientInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	// gofail: var GetSlow string
+	if term, ok := failpoint.Eval("etcd/GetSlow"); ok {
+		if delay, err := time.ParseDuration(term); err == nil {
+			time.Sleep(delay)
+		}
+	}
+	// TODO: make sure this works with etcd2 (root key may not exist)
+	if _, err := etcdClient.Get("/", false, false); err != nil && !tools.IsEtcdNotFound(err) {
+		return nil, fmt.Errorf("Could not reach etcd: %v", err)
+	}
+
+	return etcdClient, nil
+}
+
+// healthyEtcdEndpointsFirst reorders urls so that endpoints which currently
+// respond to a cheap liveness check sort before ones that don't, without
+// dropping any entry. The go-etcd client tries endpoints in list order on
+// each call, so this is enough to keep a downed member from being retried
+// first on every request.
+func healthyEtcdEndpointsFirst(urls []string) []string {
+	if len(urls) < 2 {
+		return urls
+	}
+	healthy := make([]string, 0, len(urls))
+	unhealthy := make([]string, 0, len(urls))
+	for _, u := range urls {
+		if isEtcdEndpointAlive(u) {
+			healthy = append(healthy, u)
+		} else {
+			unhealthy = append(unhealthy, u)
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+// isEtcdEndpointAlive reports whether a TCP connection to the endpoint can be
+// established within a short deadline. It is intentionally cheap: it is used
+// to order candidate endpoints, not to make availability decisions.
+func isEtcdEndpointAlive(rawurl string) bool {
+	host := rawurl
+	if idx := strings.Index(rawurl, "://"); idx != -1 {
+		host = rawurl[idx+3:]
+	}
+	conn, err := net.DialTimeout("tcp", host, 250*time.Millisecond)
+	if err != nil {
+		return false
+	}
+	conn.Close()
+	return true
+}
+
 // NewOpenShiftEtcdHelper returns an EtcdHelper for the provided arguments or an error if the version
 // is incorrect.
 func NewOpenShiftEtcdHelper(etcdClientInfo configapi.EtcdConnectionInfo) (helper tools.EtcdHelper, err error) {
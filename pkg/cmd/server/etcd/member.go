@@ -0,0 +1,121 @@
+package etcd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/coreos/etcd/client"
+	"github.com/golang/glog"
+	"github.com/spf13/cobra"
+	"golang.org/x/net/context"
+)
+
+const adminLong = `Manage the membership of a running embedded etcd cluster.
+
+These commands talk to a live cluster over one of its client URLs and are
+safe to run against a cluster that masters are currently serving requests
+from; they do not touch the local etcd data directory.`
+
+// NewCommandAdmin returns the "etcd" administration command, letting a
+// cluster-admin add, remove, or list members of a running embedded etcd
+// cluster without having to install a separate etcdctl binary.
+func NewCommandAdmin(name string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   name,
+		Short: "Manage embedded etcd cluster membership",
+		Long:  adminLong,
+	}
+	cmd.AddCommand(newCommandMemberList())
+	cmd.AddCommand(newCommandMemberAdd())
+	cmd.AddCommand(newCommandMemberRemove())
+	return cmd
+}
+
+// membersAPI returns a client.MembersAPI for the cluster reachable at
+// endpoints, picking healthy endpoints first the same way GetAndTestEtcdClient
+// does.
+func membersAPI(endpoints []string) (client.MembersAPI, error) {
+	cfg := client.Config{
+		Endpoints: healthyEtcdEndpointsFirst(endpoints),
+	}
+	c, err := client.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to build etcd client: %v", err)
+	}
+	return client.NewMembersAPI(c), nil
+}
+
+func newCommandMemberList() *cobra.Command {
+	var endpoints []string
+	cmd := &cobra.Command{
+		Use:   "member-list",
+		Short: "List the members of an etcd cluster",
+		Run: func(c *cobra.Command, args []string) {
+			api, err := membersAPI(endpoints)
+			if err != nil {
+				glog.Fatalf("%v", err)
+			}
+			members, err := api.List(context.Background())
+			if err != nil {
+				glog.Fatalf("unable to list members: %v", err)
+			}
+			for _, m := range members {
+				fmt.Printf("%s\t%s\t%s\n", m.ID, m.Name, strings.Join(m.PeerURLs, ","))
+			}
+		},
+	}
+	cmd.Flags().StringSliceVar(&endpoints, "endpoints", nil, "Client URLs of one or more existing cluster members")
+	return cmd
+}
+
+func newCommandMemberAdd() *cobra.Command {
+	var endpoints []string
+	var peerURLs []string
+	cmd := &cobra.Command{
+		Use:   "member-add <name>",
+		Short: "Add a new member to an etcd cluster",
+		Run: func(c *cobra.Command, args []string) {
+			if len(args) != 1 {
+				glog.Fatalf("exactly one member name is required")
+			}
+			if len(peerURLs) == 0 {
+				glog.Fatalf("--peer-urls is required")
+			}
+			api, err := membersAPI(endpoints)
+			if err != nil {
+				glog.Fatalf("%v", err)
+			}
+			member, err := api.Add(context.Background(), peerURLs[0])
+			if err != nil {
+				glog.Fatalf("unable to add member: %v", err)
+			}
+			fmt.Printf("Added member %s (%s)\n", member.ID, strings.Join(member.PeerURLs, ","))
+		},
+	}
+	cmd.Flags().StringSliceVar(&endpoints, "endpoints", nil, "Client URLs of one or more existing cluster members")
+	cmd.Flags().StringSliceVar(&peerURLs, "peer-urls", nil, "Peer URL the new member will advertise; only the first value given is registered")
+	return cmd
+}
+
+func newCommandMemberRemove() *cobra.Command {
+	var endpoints []string
+	cmd := &cobra.Command{
+		Use:   "member-remove <member-id>",
+		Short: "Remove a member from an etcd cluster",
+		Run: func(c *cobra.Command, args []string) {
+			if len(args) != 1 {
+				glog.Fatalf("exactly one member ID is required")
+			}
+			api, err := membersAPI(endpoints)
+			if err != nil {
+				glog.Fatalf("%v", err)
+			}
+			if err := api.Remove(context.Background(), args[0]); err != nil {
+				glog.Fatalf("unable to remove member %s: %v", args[0], err)
+			}
+			fmt.Printf("Removed member %s\n", args[0])
+		},
+	}
+	cmd.Flags().StringSliceVar(&endpoints, "endpoints", nil, "Client URLs of one or more existing cluster members")
+	return cmd
+}
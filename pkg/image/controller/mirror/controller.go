@@ -0,0 +1,219 @@
+// Package mirror replicates ImageStream tags to peer registries as defined
+// by MirrorPolicy resources, preserving the exact signed manifest and blob
+// bytes so a mirrored image verifies identically to its source.
+package mirror
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/docker/distribution"
+	"github.com/docker/distribution/manifest"
+	"github.com/docker/distribution/registry/client"
+	"github.com/golang/glog"
+	"golang.org/x/net/context"
+
+	kapi "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	kclient "github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/client/cache"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/controller/framework"
+	kruntime "github.com/GoogleCloudPlatform/kubernetes/pkg/runtime"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/util"
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/watch"
+
+	osclient "github.com/openshift/origin/pkg/client"
+)
+
+// mirrorPoliciesResource is the REST resource name MirrorPolicy objects are
+// served under. No apiserver in this project installs it yet, so
+// NewController is handed a RESTClient directly rather than reaching it
+// through osclient.Interface the way ImageStreams is.
+const mirrorPoliciesResource = "mirrorpolicies"
+
+// resyncPeriod bounds how long the controller can lag behind a MirrorPolicy
+// change that isn't delivered as a watch event.
+const resyncPeriod = 10 * time.Minute
+
+// workerPeriod is how often the controller drains the parts of the retry
+// queue that have cleared their backoff.
+const workerPeriod = time.Second
+
+// Controller watches MirrorPolicies and, on each policy add, update or
+// resync, replicates the tags the policy selects on its source ImageStream to
+// each of its peers. ImageStreams are read, not watched: a tag added to a
+// source stream is picked up at its policy's next event or resync.
+type Controller struct {
+	client       osclient.Interface
+	policyClient *kclient.RESTClient
+	localURL     string
+	queue        *PersistentQueue
+
+	policyStore cache.Store
+	policyCtrl  *framework.Controller
+}
+
+// NewController returns a Controller that replicates from the local
+// registry at localURL using oc for ImageStream access and policyClient to
+// list and watch MirrorPolicies, persisting and retrying in-flight work
+// through queue.
+func NewController(oc osclient.Interface, policyClient *kclient.RESTClient, localURL string, queue *PersistentQueue) *Controller {
+	c := &Controller{client: oc, policyClient: policyClient, localURL: localURL, queue: queue}
+
+	c.policyStore, c.policyCtrl = framework.NewInformer(
+		&cache.ListWatch{
+			ListFunc: func() (kruntime.Object, error) {
+				list := &MirrorPolicyList{}
+				err := c.policyClient.Get().Namespace(kapi.NamespaceAll).Resource(mirrorPoliciesResource).Do().Into(list)
+				return list, err
+			},
+			WatchFunc: func(resourceVersion string) (watch.Interface, error) {
+				return c.policyClient.Get().
+					Prefix("watch").
+					Namespace(kapi.NamespaceAll).
+					Resource(mirrorPoliciesResource).
+					Param("resourceVersion", resourceVersion).
+					Watch()
+			},
+		},
+		&MirrorPolicy{},
+		resyncPeriod,
+		framework.ResourceEventHandlerFuncs{
+			AddFunc:    func(obj interface{}) { c.enqueuePolicy(obj.(*MirrorPolicy)) },
+			UpdateFunc: func(old, cur interface{}) { c.enqueuePolicy(cur.(*MirrorPolicy)) },
+		},
+	)
+
+	return c
+}
+
+// Run starts processing MirrorPolicy events and draining the retry queue
+// until stopCh is closed.
+func (c *Controller) Run(stopCh <-chan struct{}) {
+	go c.policyCtrl.Run(stopCh)
+	go util.Until(c.worker, workerPeriod, stopCh)
+	<-stopCh
+}
+
+// Sync enqueues policy's current tag/peer pairs and immediately drains them,
+// rather than waiting for the next worker tick or a watch event to deliver
+// policy. It is primarily useful for tests that want a deterministic point
+// at which replication has happened without standing up a real MirrorPolicy
+// REST endpoint for the informer in NewController to list and watch.
+func (c *Controller) Sync(policy *MirrorPolicy) {
+	c.enqueuePolicy(policy)
+	c.worker()
+}
+
+// NewMirrorPolicyRESTClient returns a RESTClient for the "mirrorpolicies"
+// resource at config's host, through the mirrorAPIVersion scheme
+// MirrorPolicy and MirrorPolicyList are registered with. Pass its result as
+// NewController's policyClient.
+func NewMirrorPolicyRESTClient(config *kclient.Config) (*kclient.RESTClient, error) {
+	versioned := *config
+	versioned.Version = mirrorAPIVersion
+	return kclient.RESTClientFor(&versioned)
+}
+
+// enqueuePolicy schedules a push for every (tag, peer) pair the policy
+// currently selects on its source ImageStream.
+func (c *Controller) enqueuePolicy(policy *MirrorPolicy) {
+	stream, err := c.client.ImageStreams(policy.Namespace).Get(policy.Spec.Source)
+	if err != nil {
+		glog.Errorf("mirror: error reading source ImageStream %s/%s: %v", policy.Namespace, policy.Spec.Source, err)
+		return
+	}
+	for tag := range stream.Status.Tags {
+		if !policy.Spec.Tags.Matches(tag) {
+			continue
+		}
+		for _, peer := range policy.Spec.Peers {
+			item := workItem{Namespace: policy.Namespace, Stream: stream.Name, Tag: tag, Peer: peer}
+			if err := c.queue.Push(item); err != nil {
+				glog.Errorf("mirror: error queueing %s/%s:%s -> %s: %v", policy.Namespace, stream.Name, tag, peer, err)
+			}
+		}
+	}
+}
+
+// worker drains whatever part of the retry queue has cleared its backoff.
+func (c *Controller) worker() {
+	for _, item := range c.queue.Pending() {
+		err := c.replicate(item)
+		recordMirrorPush(err)
+		if err != nil {
+			glog.Errorf("mirror: error replicating %s/%s:%s -> %s: %v", item.Namespace, item.Stream, item.Tag, item.Peer, err)
+			if rerr := c.queue.Reschedule(item); rerr != nil {
+				glog.Errorf("mirror: error rescheduling %s/%s:%s -> %s: %v", item.Namespace, item.Stream, item.Tag, item.Peer, rerr)
+			}
+			continue
+		}
+		if err := c.queue.Done(item); err != nil {
+			glog.Errorf("mirror: error clearing completed %s/%s:%s -> %s: %v", item.Namespace, item.Stream, item.Tag, item.Peer, err)
+		}
+	}
+}
+
+// replicate copies item.Tag's manifest, and every blob layer it references,
+// from the local registry to item.Peer without re-signing, so the copy
+// verifies under the exact digest it had at the source.
+func (c *Controller) replicate(item workItem) error {
+	repoName := fmt.Sprintf("%s/%s", item.Namespace, item.Stream)
+	ctx := context.Background()
+
+	local, err := client.NewRepository(ctx, repoName, c.localURL, nil)
+	if err != nil {
+		return fmt.Errorf("error opening local repository %s: %v", repoName, err)
+	}
+	peer, err := client.NewRepository(ctx, repoName, item.Peer, nil)
+	if err != nil {
+		return fmt.Errorf("error opening peer repository %s at %s: %v", repoName, item.Peer, err)
+	}
+
+	localManifests, err := local.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	signed, err := localManifests.Get(item.Tag)
+	if err != nil {
+		return fmt.Errorf("error reading local manifest %s:%s: %v", repoName, item.Tag, err)
+	}
+
+	if err := copyBlobs(ctx, local, peer, signed); err != nil {
+		return fmt.Errorf("error copying blobs for %s:%s: %v", repoName, item.Tag, err)
+	}
+
+	peerManifests, err := peer.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	// Putting the SignedManifest's original bytes verbatim - rather than
+	// re-marshaling and re-signing manifest.Manifest - is what makes this a
+	// digest-preserving copy.
+	return peerManifests.Put(signed)
+}
+
+// copyBlobs streams every blob layer signed references from src to dst,
+// skipping layers dst already has.
+func copyBlobs(ctx context.Context, src, dst distribution.Repository, signed *manifest.SignedManifest) error {
+	srcBlobs := src.Blobs(ctx)
+	dstBlobs := dst.Blobs(ctx)
+	for _, fsLayer := range signed.FSLayers {
+		if _, err := dstBlobs.Stat(ctx, fsLayer.BlobSum); err == nil {
+			continue
+		}
+		reader, err := srcBlobs.Open(ctx, fsLayer.BlobSum)
+		if err != nil {
+			return fmt.Errorf("error reading blob %s: %v", fsLayer.BlobSum, err)
+		}
+		content, err := ioutil.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return fmt.Errorf("error reading blob %s: %v", fsLayer.BlobSum, err)
+		}
+		if _, err := dstBlobs.Put(ctx, "", content); err != nil {
+			return fmt.Errorf("error writing blob %s: %v", fsLayer.BlobSum, err)
+		}
+	}
+	return nil
+}
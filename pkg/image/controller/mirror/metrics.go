@@ -0,0 +1,25 @@
+package mirror
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// mirrorPushesTotal counts completed replication attempts, partitioned by
+// outcome, so operators can alert on a mirror target falling behind.
+var mirrorPushesTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "mirror_pushes_total",
+		Help: "Number of image mirror pushes to peer registries, by result.",
+	},
+	[]string{"result"},
+)
+
+func init() {
+	prometheus.MustRegister(mirrorPushesTotal)
+}
+
+func recordMirrorPush(err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	mirrorPushesTotal.WithLabelValues(result).Inc()
+}
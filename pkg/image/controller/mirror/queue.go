@@ -0,0 +1,141 @@
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	etcdclient "github.com/coreos/go-etcd/etcd"
+
+	"github.com/GoogleCloudPlatform/kubernetes/pkg/tools"
+)
+
+// workItem is one pending (or backed-off) replication job: copy tag from the
+// source ImageStream to a single peer registry.
+type workItem struct {
+	Namespace string    `json:"namespace"`
+	Stream    string    `json:"stream"`
+	Tag       string    `json:"tag"`
+	Peer      string    `json:"peer"`
+	Attempts  int       `json:"attempts"`
+	NotBefore time.Time `json:"notBefore"`
+}
+
+// key is this item's etcd key and its identity within the in-memory index:
+// at most one pending job exists per (stream, tag, peer) triple.
+func (w workItem) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s/%s", queueKeyPrefix, w.Namespace, w.Stream, w.Tag, w.Peer)
+}
+
+// queueKeyPrefix is where pending work items are persisted, so a restart of
+// the controller resumes any replication that was in flight or backing off
+// rather than silently dropping it.
+const queueKeyPrefix = "/openshift.io/mirror/queue"
+
+// maxBackoff caps how long Reschedule will ever delay a retry.
+const maxBackoff = 5 * time.Minute
+
+// PersistentQueue is a retry queue for replication work items backed by
+// etcd: Push/Reschedule/Done keep an in-memory index and the etcd copy in
+// sync so Pending() is cheap, while the etcd copy lets NewPersistentQueue
+// rebuild the index after a controller restart.
+type PersistentQueue struct {
+	client *etcdclient.Client
+
+	mu    sync.Mutex
+	items map[string]workItem
+}
+
+// NewPersistentQueue returns a PersistentQueue backed by client, restoring
+// any work items left over from a previous run.
+func NewPersistentQueue(client *etcdclient.Client) (*PersistentQueue, error) {
+	q := &PersistentQueue{client: client, items: map[string]workItem{}}
+	resp, err := client.Get(queueKeyPrefix, false, true)
+	if err != nil {
+		if tools.IsEtcdNotFound(err) {
+			return q, nil
+		}
+		return nil, fmt.Errorf("error listing persisted mirror queue: %v", err)
+	}
+	for _, node := range flattenNodes(resp.Node) {
+		var item workItem
+		if err := json.Unmarshal([]byte(node.Value), &item); err != nil {
+			continue
+		}
+		q.items[item.key()] = item
+	}
+	return q, nil
+}
+
+func flattenNodes(node *etcdclient.Node) []*etcdclient.Node {
+	if node == nil {
+		return nil
+	}
+	if !node.Dir {
+		return []*etcdclient.Node{node}
+	}
+	leaves := []*etcdclient.Node{}
+	for _, child := range node.Nodes {
+		leaves = append(leaves, flattenNodes(child)...)
+	}
+	return leaves
+}
+
+// Push persists item, scheduling its first attempt immediately.
+func (q *PersistentQueue) Push(item workItem) error {
+	return q.store(item)
+}
+
+// Reschedule persists item with its attempt count incremented and its next
+// attempt backed off exponentially, capped at maxBackoff.
+func (q *PersistentQueue) Reschedule(item workItem) error {
+	item.Attempts++
+	backoff := maxBackoff
+	if shift := uint(item.Attempts); shift < 32 {
+		if d := time.Duration(1<<shift) * time.Second; d < maxBackoff {
+			backoff = d
+		}
+	}
+	item.NotBefore = time.Now().Add(backoff)
+	return q.store(item)
+}
+
+func (q *PersistentQueue) store(item workItem) error {
+	raw, err := json.Marshal(item)
+	if err != nil {
+		return err
+	}
+	if _, err := q.client.Set(item.key(), string(raw), 0); err != nil {
+		return fmt.Errorf("error persisting mirror queue item: %v", err)
+	}
+	q.mu.Lock()
+	q.items[item.key()] = item
+	q.mu.Unlock()
+	return nil
+}
+
+// Done removes item from the queue after a successful push.
+func (q *PersistentQueue) Done(item workItem) error {
+	q.mu.Lock()
+	delete(q.items, item.key())
+	q.mu.Unlock()
+	if _, err := q.client.Delete(item.key(), false); err != nil && !tools.IsEtcdNotFound(err) {
+		return fmt.Errorf("error removing mirror queue item: %v", err)
+	}
+	return nil
+}
+
+// Pending returns every item whose NotBefore has passed.
+func (q *PersistentQueue) Pending() []workItem {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	pending := []workItem{}
+	for _, item := range q.items {
+		if !item.NotBefore.After(now) {
+			pending = append(pending, item)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,69 @@
+package mirror
+
+import (
+	kapi "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+)
+
+// MirrorPolicy selects tags on one ImageStream and replicates them,
+// digest-for-digest, to one or more peer registries.
+type MirrorPolicy struct {
+	kapi.TypeMeta   `json:",inline"`
+	kapi.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec MirrorPolicySpec `json:"spec"`
+}
+
+// MirrorPolicySpec is the desired replication behavior for a MirrorPolicy.
+type MirrorPolicySpec struct {
+	// Source is the ImageStream this policy watches, in the same namespace
+	// as the MirrorPolicy itself.
+	Source string `json:"source"`
+
+	// Peers lists the base URLs of registries that mirrored tags should be
+	// pushed to, e.g. "https://registry.example.com".
+	Peers []string `json:"peers"`
+
+	// Tags filters which tags on Source are replicated. An empty Include
+	// matches every tag. Exclude is applied after Include.
+	Tags TagFilter `json:"tags"`
+}
+
+// TagFilter includes or excludes tags by exact name.
+type TagFilter struct {
+	Include []string `json:"include,omitempty"`
+	Exclude []string `json:"exclude,omitempty"`
+}
+
+// Matches reports whether tag should be replicated under f.
+func (f TagFilter) Matches(tag string) bool {
+	if len(f.Include) > 0 && !contains(f.Include, tag) {
+		return false
+	}
+	return !contains(f.Exclude, tag)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// MirrorPolicyList is a list of MirrorPolicy.
+type MirrorPolicyList struct {
+	kapi.TypeMeta `json:",inline"`
+	kapi.ListMeta `json:"metadata,omitempty"`
+
+	Items []MirrorPolicy `json:"items"`
+}
+
+// mirrorAPIVersion is the API version MirrorPolicy and MirrorPolicyList are
+// registered under, so a RESTClient built against the "mirrorpolicies"
+// resource can encode and decode them like any other versioned API object.
+const mirrorAPIVersion = "v1beta3"
+
+func init() {
+	kapi.Scheme.AddKnownTypes(mirrorAPIVersion, &MirrorPolicy{}, &MirrorPolicyList{})
+}
@@ -0,0 +1,33 @@
+// +build !failpoints
+
+// Package failpoint provides optional fault-injection points for the
+// integration test suite. Call sites mark an injection point with a
+// "// gofail:" comment (mirroring the gofail convention used by etcd itself)
+// immediately above a failpoint.Eval call, and a test arms that point by name
+// over the HTTP admin endpoint returned by Handler.
+//
+// Every function here is a no-op unless the binary is built with the
+// "failpoints" tag, so production binaries pay zero cost for the
+// instrumentation left at call sites.
+package failpoint
+
+import "net/http"
+
+// Eval reports whether the named failpoint is currently armed and, if so,
+// returns the term it was armed with (for example a duration or an error
+// string, interpreted by the call site). It always returns ("", false) in
+// binaries built without the "failpoints" tag.
+func Eval(name string) (string, bool) {
+	return "", false
+}
+
+// Handler returns the failpoint admin endpoint, or nil when the binary was
+// not built with the "failpoints" tag. Callers must only mount it
+// conditionally:
+//
+//	if h := failpoint.Handler(); h != nil {
+//		mux.Handle("/debug/failpoints/", h)
+//	}
+func Handler() http.Handler {
+	return nil
+}
@@ -0,0 +1,66 @@
+// +build failpoints
+
+package failpoint
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	mu     sync.RWMutex
+	points = map[string]string{}
+)
+
+// Eval reports whether name is armed and, if so, the term it was armed with.
+func Eval(name string) (string, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	term, ok := points[name]
+	return term, ok
+}
+
+// Handler serves the failpoint admin API at "/debug/failpoints/<name>", the
+// path it must be mounted under, since it strips that exact prefix to find the
+// point's name: GET lists armed points (or fetches one by name), PUT arms the
+// named point with the request body as its term, and DELETE disarms it.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/debug/failpoints/")
+		switch r.Method {
+		case http.MethodGet:
+			mu.RLock()
+			defer mu.RUnlock()
+			if len(name) == 0 {
+				for n, term := range points {
+					fmt.Fprintf(w, "%s=%s\n", n, term)
+				}
+				return
+			}
+			term, ok := points[name]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			fmt.Fprintln(w, term)
+		case http.MethodPut:
+			body, err := ioutil.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			mu.Lock()
+			points[name] = strings.TrimSpace(string(body))
+			mu.Unlock()
+		case http.MethodDelete:
+			mu.Lock()
+			delete(points, name)
+			mu.Unlock()
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+}
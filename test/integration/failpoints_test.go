@@ -0,0 +1,163 @@
+// +build integration,!no-etcd,failpoints
+
+package integration
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/openshift/origin/pkg/cmd/dockerregistry"
+	"github.com/openshift/origin/pkg/cmd/util/tokencmd"
+	"github.com/openshift/origin/pkg/util/failpoint"
+	testutil "github.com/openshift/origin/test/util"
+)
+
+// TestFailpointAdminEndpoint exercises the failpoint admin HTTP API that is
+// only compiled in under the "failpoints" build tag, arming and disarming a
+// named point the same way a developer reproducing a flake with
+// "etcd/GetSlow" would.
+func TestFailpointAdminEndpoint(t *testing.T) {
+	server := httptest.NewServer(failpoint.Handler())
+	defer server.Close()
+
+	const name = "etcd/GetSlow"
+	arm, err := http.NewRequest("PUT", server.URL+"/debug/failpoints/"+name, strings.NewReader("50ms"))
+	if err != nil {
+		t.Fatalf("error building arm request: %v", err)
+	}
+	if _, err := http.DefaultClient.Do(arm); err != nil {
+		t.Fatalf("error arming failpoint: %v", err)
+	}
+
+	term, ok := failpoint.Eval(name)
+	if !ok || term != "50ms" {
+		t.Fatalf("expected %q armed with term %q, got %q (armed=%v)", name, "50ms", term, ok)
+	}
+	if _, err := time.ParseDuration(term); err != nil {
+		t.Fatalf("armed term is not a valid duration: %v", err)
+	}
+
+	disarm, err := http.NewRequest("DELETE", server.URL+"/debug/failpoints/"+name, nil)
+	if err != nil {
+		t.Fatalf("error building disarm request: %v", err)
+	}
+	if _, err := http.DefaultClient.Do(disarm); err != nil {
+		t.Fatalf("error disarming failpoint: %v", err)
+	}
+	if _, ok := failpoint.Eval(name); ok {
+		t.Fatalf("expected %q to be disarmed", name)
+	}
+}
+
+// TestFailpointImageStreamUpdateConflict arms the "imagestream/UpdateConflict"
+// point on a running registry's mounted admin endpoint and confirms that a
+// real pull-through manifest GET takes the injected failure: the manifest is
+// still served (mirroring failures are logged, not fatal to the request),
+// but the ImageStream it would have mirrored into is left without the tag,
+// exercising the real registry/image-stream code path rather than the
+// failpoint admin endpoint in isolation.
+func TestFailpointImageStreamUpdateConflict(t *testing.T) {
+	signed, _, err := signedManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fmt.Sprintf("/v2/%s/proxied/manifests/latest", testutil.Namespace()) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(signed)
+	}))
+	defer upstream.Close()
+
+	_, clusterAdminKubeConfig, err := testutil.StartTestMaster()
+	if err != nil {
+		t.Fatalf("error starting master: %v", err)
+	}
+	clusterAdminClient, err := testutil.GetClusterAdminClient(clusterAdminKubeConfig)
+	if err != nil {
+		t.Fatalf("error getting cluster admin client: %v", err)
+	}
+	clusterAdminClientConfig, err := testutil.GetClusterAdminClientConfig(clusterAdminKubeConfig)
+	if err != nil {
+		t.Fatalf("error getting cluster admin client config: %v", err)
+	}
+	adminUser := "admin"
+	if _, err := testutil.CreateNewProject(clusterAdminClient, *clusterAdminClientConfig, testutil.Namespace(), adminUser); err != nil {
+		t.Fatalf("error creating project: %v", err)
+	}
+	token, err := tokencmd.RequestToken(clusterAdminClientConfig, nil, adminUser, "password")
+	if err != nil {
+		t.Fatalf("error requesting token: %v", err)
+	}
+
+	config := fmt.Sprintf(`version: 0.1
+loglevel: debug
+http:
+  addr: 127.0.0.1:5003
+storage:
+  inmemory: {}
+auth:
+  openshift:
+middleware:
+  repository:
+    - name: openshift
+proxy:
+  remoteurl: %s
+`, upstream.URL)
+
+	os.Setenv("OPENSHIFT_CA_DATA", string(clusterAdminClientConfig.CAData))
+	os.Setenv("OPENSHIFT_CERT_DATA", string(clusterAdminClientConfig.CertData))
+	os.Setenv("OPENSHIFT_KEY_DATA", string(clusterAdminClientConfig.KeyData))
+	os.Setenv("OPENSHIFT_MASTER", clusterAdminClientConfig.Host)
+	os.Setenv("REGISTRY_URL", "127.0.0.1:5003")
+
+	go dockerregistry.Execute(strings.NewReader(config))
+	<-etcdReady
+
+	const point = "imagestream/UpdateConflict"
+	arm, err := http.NewRequest("PUT", "http://127.0.0.1:5003/debug/failpoints/"+point, strings.NewReader("injected by test"))
+	if err != nil {
+		t.Fatalf("error building arm request: %v", err)
+	}
+	if _, err := http.DefaultClient.Do(arm); err != nil {
+		t.Fatalf("error arming failpoint: %v", err)
+	}
+	defer func() {
+		disarm, err := http.NewRequest("DELETE", "http://127.0.0.1:5003/debug/failpoints/"+point, nil)
+		if err != nil {
+			t.Fatalf("error building disarm request: %v", err)
+		}
+		if _, err := http.DefaultClient.Do(disarm); err != nil {
+			t.Fatalf("error disarming failpoint: %v", err)
+		}
+	}()
+
+	url := fmt.Sprintf("http://127.0.0.1:5003/v2/%s/proxied/manifests/latest", testutil.Namespace())
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.SetBasicAuth(adminUser, token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error requesting proxied manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	stream, err := clusterAdminClient.ImageStreams(testutil.Namespace()).Get("proxied")
+	if err != nil {
+		t.Fatalf("error getting ImageStream created by the failed mirror attempt: %v", err)
+	}
+	if events, ok := stream.Status.Tags["latest"]; ok && len(events.Items) > 0 {
+		t.Fatalf("expected the injected conflict to prevent the %q tag from being recorded, got %#v", "latest", events)
+	}
+}
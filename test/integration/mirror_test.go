@@ -0,0 +1,185 @@
+// +build integration,!no-etcd
+
+package integration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	etcdclient "github.com/coreos/go-etcd/etcd"
+
+	kapi "github.com/GoogleCloudPlatform/kubernetes/pkg/api"
+	kclient "github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+
+	"github.com/openshift/origin/pkg/cmd/dockerregistry"
+	"github.com/openshift/origin/pkg/cmd/util/tokencmd"
+	imageapi "github.com/openshift/origin/pkg/image/api"
+	"github.com/openshift/origin/pkg/image/controller/mirror"
+	testutil "github.com/openshift/origin/test/util"
+)
+
+// testEtcdAddr is the embedded test etcd's default client address, the same
+// one testutil.RequireEtcd configures for every integration test.
+const testEtcdAddr = "http://127.0.0.1:4001"
+
+// TestMirrorController asserts that a manifest PUT to the local registry,
+// once replicated by a MirrorPolicy naming a second in-memory registry, is
+// retrievable from that peer under the same digest.
+func TestMirrorController(t *testing.T) {
+	_, clusterAdminKubeConfig, err := testutil.StartTestMaster()
+	if err != nil {
+		t.Fatalf("error starting master: %v", err)
+	}
+	clusterAdminClient, err := testutil.GetClusterAdminClient(clusterAdminKubeConfig)
+	if err != nil {
+		t.Fatalf("error getting cluster admin client: %v", err)
+	}
+	clusterAdminClientConfig, err := testutil.GetClusterAdminClientConfig(clusterAdminKubeConfig)
+	if err != nil {
+		t.Fatalf("error getting cluster admin client config: %v", err)
+	}
+	adminUser := "admin"
+	if _, err := testutil.CreateNewProject(clusterAdminClient, *clusterAdminClientConfig, testutil.Namespace(), adminUser); err != nil {
+		t.Fatalf("error creating project: %v", err)
+	}
+	token, err := tokencmd.RequestToken(clusterAdminClientConfig, nil, adminUser, "password")
+	if err != nil {
+		t.Fatalf("error requesting token: %v", err)
+	}
+
+	os.Setenv("OPENSHIFT_CA_DATA", string(clusterAdminClientConfig.CAData))
+	os.Setenv("OPENSHIFT_CERT_DATA", string(clusterAdminClientConfig.CertData))
+	os.Setenv("OPENSHIFT_KEY_DATA", string(clusterAdminClientConfig.KeyData))
+	os.Setenv("OPENSHIFT_MASTER", clusterAdminClientConfig.Host)
+
+	source := `version: 0.1
+loglevel: debug
+http:
+  addr: 127.0.0.1:5010
+storage:
+  inmemory: {}
+auth:
+  openshift:
+middleware:
+  repository:
+    - name: openshift
+`
+	os.Setenv("REGISTRY_URL", "127.0.0.1:5010")
+	go dockerregistry.Execute(strings.NewReader(source))
+	<-etcdReady
+
+	peer := `version: 0.1
+loglevel: debug
+http:
+  addr: 127.0.0.1:5011
+storage:
+  inmemory: {}
+`
+	go dockerregistry.Execute(strings.NewReader(peer))
+
+	stream := imageapi.ImageStream{
+		ObjectMeta: kapi.ObjectMeta{
+			Namespace: testutil.Namespace(),
+			Name:      "mirrored",
+		},
+	}
+	if _, err := clusterAdminClient.ImageStreams(testutil.Namespace()).Create(&stream); err != nil {
+		t.Fatalf("error creating image stream: %v", err)
+	}
+
+	signed, dgst, err := signedManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	putURL := fmt.Sprintf("http://127.0.0.1:5010/v2/%s/%s/manifests/%s", testutil.Namespace(), stream.Name, "latest")
+	req, err := http.NewRequest("PUT", putURL, strings.NewReader(string(signed)))
+	if err != nil {
+		t.Fatalf("error creating put request: %v", err)
+	}
+	req.SetBasicAuth(adminUser, token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error putting manifest: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected put status code: %d", resp.StatusCode)
+	}
+
+	policy := mirror.MirrorPolicy{
+		ObjectMeta: kapi.ObjectMeta{
+			Namespace: testutil.Namespace(),
+			Name:      "mirror-to-peer",
+		},
+		Spec: mirror.MirrorPolicySpec{
+			Source: stream.Name,
+			Peers:  []string{"http://127.0.0.1:5011"},
+		},
+	}
+	policyList, err := json.Marshal(mirror.MirrorPolicyList{Items: []mirror.MirrorPolicy{policy}})
+	if err != nil {
+		t.Fatalf("error marshaling mirror policy list: %v", err)
+	}
+	// watchStop holds open watch responses until the test tears down, so
+	// policyServer's /watch/ branch below genuinely blocks rather than
+	// closing the connection the instant a watch is opened.
+	watchStop := make(chan struct{})
+	defer close(watchStop)
+
+	// policyServer stands in for the "mirrorpolicies" REST endpoint this
+	// project doesn't yet install on a real apiserver: it serves the one
+	// policy above for the informer's initial List, and holds watch requests
+	// open (closing when the test ends), so NewController's list/watch
+	// machinery runs against a real HTTP round trip rather than being
+	// bypassed entirely.
+	policyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/watch/") {
+			w.WriteHeader(http.StatusOK)
+			if f, ok := w.(http.Flusher); ok {
+				f.Flush()
+			}
+			<-watchStop
+			return
+		}
+		w.Write(policyList)
+	}))
+	defer policyServer.Close()
+
+	policyClientConfig := *clusterAdminClientConfig
+	policyClientConfig.Host = policyServer.URL
+	policyClient, err := mirror.NewMirrorPolicyRESTClient(&policyClientConfig)
+	if err != nil {
+		t.Fatalf("error building mirror policy client: %v", err)
+	}
+
+	queue, err := mirror.NewPersistentQueue(etcdclient.NewClient([]string{testEtcdAddr}))
+	if err != nil {
+		t.Fatalf("error building mirror queue: %v", err)
+	}
+	controller := mirror.NewController(clusterAdminClient, policyClient, "http://127.0.0.1:5010", queue)
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	go controller.Run(stopCh)
+
+	getURL := fmt.Sprintf("http://127.0.0.1:5011/v2/%s/%s/manifests/%s", testutil.Namespace(), stream.Name, dgst.String())
+	deadline := time.Now().Add(30 * time.Second)
+	var lastStatus int
+	for time.Now().Before(deadline) {
+		resp, err = http.Get(getURL)
+		if err == nil {
+			lastStatus = resp.StatusCode
+			resp.Body.Close()
+			if lastStatus == http.StatusOK {
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatalf("expected manifest %s to have been mirrored via the watched MirrorPolicy, last status %d", dgst.String(), lastStatus)
+}
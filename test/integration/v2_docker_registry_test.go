@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -23,8 +24,12 @@ import (
 	testutil "github.com/openshift/origin/test/util"
 )
 
+// etcdReady closes once the embedded etcd cluster backing the test master
+// has finished joining and is ready to serve requests.
+var etcdReady <-chan struct{}
+
 func init() {
-	testutil.RequireEtcd()
+	etcdReady = testutil.RequireEtcd()
 }
 
 func signedManifest() ([]byte, digest.Digest, error) {
@@ -117,6 +122,8 @@ middleware:
 
 	go dockerregistry.Execute(strings.NewReader(config))
 
+	<-etcdReady
+
 	stream := imageapi.ImageStream{
 		ObjectMeta: kapi.ObjectMeta{
 			Namespace: testutil.Namespace(),
@@ -207,6 +214,98 @@ middleware:
 	}
 }
 
+// TestV2RegistryProxyPullThrough asserts that a GET for a manifest the local
+// registry has never seen falls through to a configured upstream, and that
+// doing so auto-populates the matching ImageStream with the mirrored tag and
+// digest.
+func TestV2RegistryProxyPullThrough(t *testing.T) {
+	signed, dgst, err := signedManifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != fmt.Sprintf("/v2/%s/proxied/manifests/latest", testutil.Namespace()) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(signed)
+	}))
+	defer upstream.Close()
+
+	_, clusterAdminKubeConfig, err := testutil.StartTestMaster()
+	if err != nil {
+		t.Fatalf("error starting master: %v", err)
+	}
+	clusterAdminClient, err := testutil.GetClusterAdminClient(clusterAdminKubeConfig)
+	if err != nil {
+		t.Fatalf("error getting cluster admin client: %v", err)
+	}
+	clusterAdminClientConfig, err := testutil.GetClusterAdminClientConfig(clusterAdminKubeConfig)
+	if err != nil {
+		t.Fatalf("error getting cluster admin client config: %v", err)
+	}
+	adminUser := "admin"
+	if _, err := testutil.CreateNewProject(clusterAdminClient, *clusterAdminClientConfig, testutil.Namespace(), adminUser); err != nil {
+		t.Fatalf("error creating project: %v", err)
+	}
+	token, err := tokencmd.RequestToken(clusterAdminClientConfig, nil, adminUser, "password")
+	if err != nil {
+		t.Fatalf("error requesting token: %v", err)
+	}
+
+	config := fmt.Sprintf(`version: 0.1
+loglevel: debug
+http:
+  addr: 127.0.0.1:5001
+storage:
+  inmemory: {}
+auth:
+  openshift:
+middleware:
+  repository:
+    - name: openshift
+proxy:
+  remoteurl: %s
+`, upstream.URL)
+
+	os.Setenv("OPENSHIFT_CA_DATA", string(clusterAdminClientConfig.CAData))
+	os.Setenv("OPENSHIFT_CERT_DATA", string(clusterAdminClientConfig.CertData))
+	os.Setenv("OPENSHIFT_KEY_DATA", string(clusterAdminClientConfig.KeyData))
+	os.Setenv("OPENSHIFT_MASTER", clusterAdminClientConfig.Host)
+	os.Setenv("REGISTRY_URL", "127.0.0.1:5001")
+
+	go dockerregistry.Execute(strings.NewReader(config))
+	<-etcdReady
+
+	url := fmt.Sprintf("http://127.0.0.1:5001/v2/%s/proxied/manifests/latest", testutil.Namespace())
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		t.Fatalf("error creating request: %v", err)
+	}
+	req.SetBasicAuth(adminUser, token)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("error requesting proxied manifest: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	stream, err := clusterAdminClient.ImageStreams(testutil.Namespace()).Get("proxied")
+	if err != nil {
+		t.Fatalf("error getting mirrored ImageStream: %v", err)
+	}
+	events, ok := stream.Status.Tags["latest"]
+	if !ok || len(events.Items) == 0 {
+		t.Fatalf("expected ImageStream %s/proxied to have a mirrored %q tag, got %#v", testutil.Namespace(), "latest", stream.Status.Tags)
+	}
+	if e, a := dgst.String(), events.Items[0].Image; e != a {
+		t.Errorf("mirrored tag image: expected %q, got %q", e, a)
+	}
+}
+
 func getTags(streamName, adminUser, token string) ([]string, error) {
 	url := fmt.Sprintf("http://127.0.0.1:5000/v2/%s/%s/tags/list", testutil.Namespace(), streamName)
 	client := http.DefaultClient
@@ -0,0 +1,89 @@
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	kclient "github.com/GoogleCloudPlatform/kubernetes/pkg/client"
+
+	configapi "github.com/openshift/origin/pkg/cmd/server/api"
+	"github.com/openshift/origin/pkg/cmd/server/etcd"
+	"github.com/openshift/origin/pkg/cmd/server/start"
+)
+
+const (
+	testEtcdClientAddr = "127.0.0.1:4001"
+	testEtcdPeerAddr   = "127.0.0.1:7001"
+)
+
+var (
+	etcdStartOnce sync.Once
+	sharedEtcd    *etcd.EmbeddedEtcd
+	sharedEtcdErr error
+
+	masterStartOnce sync.Once
+	sharedMaster    *start.MasterProcess
+	sharedMasterCfg *kclient.Config
+	sharedMasterErr error
+)
+
+// RequireEtcd starts, once per test binary, the embedded etcd cluster every
+// integration test in this package runs against, and returns its
+// ReadyNotify channel. Callers should block on the returned channel instead
+// of polling before depending on the cluster it backs.
+func RequireEtcd() <-chan struct{} {
+	etcdStartOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "openshift-test-etcd")
+		if err != nil {
+			sharedEtcdErr = err
+			return
+		}
+		sharedEtcd, sharedEtcdErr = etcd.RunEtcd(&configapi.EtcdConfig{
+			StorageDir:      dir,
+			ServingInfo:     configapi.ServingInfo{BindAddress: testEtcdClientAddr},
+			PeerServingInfo: configapi.ServingInfo{BindAddress: testEtcdPeerAddr},
+			Address:         testEtcdClientAddr,
+			PeerAddress:     testEtcdPeerAddr,
+		})
+	})
+	if sharedEtcdErr != nil {
+		panic(fmt.Sprintf("unable to start test etcd: %v", sharedEtcdErr))
+	}
+	return sharedEtcd.ReadyNotify()
+}
+
+// StartTestMaster starts, once per test binary, the OpenShift master every
+// integration test in this package runs against, backed by the shared
+// embedded etcd cluster from RequireEtcd, and returns a handle to the running
+// process plus a client config for reaching it as the cluster admin. It
+// blocks on etcd's ReadyNotify channel rather than polling before bringing
+// the master up against it.
+//
+// Like RequireEtcd, repeated calls return the same master instead of each
+// starting a new one: integration tests each call StartTestMaster at the
+// start of their test function, and a master started per-call would
+// leak a listener per test function for the life of the binary.
+func StartTestMaster() (*start.MasterProcess, *kclient.Config, error) {
+	<-RequireEtcd()
+
+	masterStartOnce.Do(func() {
+		masterConfig := &configapi.MasterConfig{
+			EtcdClientInfo: configapi.EtcdConnectionInfo{
+				URLs: []string{"http://" + testEtcdClientAddr},
+			},
+		}
+
+		process, err := start.RunMaster(masterConfig)
+		if err != nil {
+			sharedMasterErr = fmt.Errorf("error starting test master: %v", err)
+			return
+		}
+		sharedMaster = process
+		sharedMasterCfg = process.AdminClientConfig()
+	})
+	if sharedMasterErr != nil {
+		return nil, nil, sharedMasterErr
+	}
+	return sharedMaster, sharedMasterCfg, nil
+}